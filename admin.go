@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// purgeRequest is the JSON body accepted by POST /_cache/purge.
+type purgeRequest struct {
+	Prefixes []string `json:"prefixes"`
+}
+
+type purgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// authorized checks the request's bearer token against CACHE_ADMIN_SECRET.
+// If no secret is configured, the admin API refuses every request rather
+// than being left open.
+func (cs *CacheServer) authorized(r *http.Request) bool {
+	if cs.adminSecret == "" {
+		return false
+	}
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	token := auth[len(prefix):]
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cs.adminSecret)) == 1
+}
+
+// PurgeHandler serves POST /_cache/purge: given a JSON body of URL path
+// prefixes, removes every cached entry under any of them.
+func (cs *CacheServer) PurgeHandler(w http.ResponseWriter, r *http.Request) {
+	if !cs.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req purgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var purged int
+	for _, prefix := range req.Prefixes {
+		purged += cs.purgeUnder(prefix)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(purgeResponse{Purged: purged})
+}
+
+// FlushHandler serves DELETE /_cache: clears the cache entirely.
+func (cs *CacheServer) FlushHandler(w http.ResponseWriter, r *http.Request) {
+	if !cs.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(purgeResponse{Purged: cs.purgeAll()})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+// Blob is the storage-agnostic handle for a cached response body. Small
+// bodies are held as memBlob ([]byte); bodies over the spill threshold
+// are written to a temp file and handled as fileBlob, so a large
+// response is never required to be fully resident in memory.
+type Blob interface {
+	io.ReaderAt
+	Size() int64
+	Close() error
+}
+
+// memBlob is a Blob backed entirely by memory.
+type memBlob []byte
+
+func (b memBlob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b memBlob) Size() int64  { return int64(len(b)) }
+func (b memBlob) Close() error { return nil }
+
+// fileBlob is a Blob backed by a file on disk, reference-counted via the
+// spillFile it wraps. Close releases this blob's own reference rather
+// than closing and removing the file outright — a coalesced follower
+// still streaming the same spillFile (via growingFile.NewReader) or a
+// concurrent cache-HIT holding a pin (see pinBlob) may still be reading
+// it, and the file is only actually torn down once every such reference
+// has been released.
+type fileBlob struct {
+	sf   *spillFile
+	size int64
+}
+
+func newFileBlob(sf *spillFile, size int64) *fileBlob {
+	return &fileBlob{sf: sf, size: size}
+}
+
+func (b *fileBlob) ReadAt(p []byte, off int64) (int, error) { return b.sf.file.ReadAt(p, off) }
+func (b *fileBlob) Size() int64                             { return b.size }
+func (b *fileBlob) Close() error                            { b.sf.release(); return nil }
+
+// pin extends the lifetime of the file backing b for as long as the
+// caller might still be reading it, so a concurrent Close (e.g. the cache
+// evicting this entry) can't have the file closed and removed out from
+// under an in-progress read. unpin must be called exactly once, when the
+// caller is done.
+func (b *fileBlob) pin()   { b.sf.acquire() }
+func (b *fileBlob) unpin() { b.sf.release() }
+
+// sameBlob reports whether a and b are the very same underlying cache
+// body rather than two equal-looking ones. memBlob (a slice) isn't
+// comparable with ==, so it's conservatively treated as always distinct
+// — safe, since memBlob.Close is a no-op.
+func sameBlob(a, b Blob) bool {
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+	if !av.Comparable() || !bv.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// blobReader returns an io.Reader over the whole of a Blob.
+func blobReader(b Blob) io.Reader {
+	return io.NewSectionReader(b, 0, b.Size())
+}
+
+// readAllBlob materializes a Blob fully into memory; used by backends
+// (e.g. Redis) that must serialize the whole body regardless of size.
+func readAllBlob(b Blob) ([]byte, error) {
+	return io.ReadAll(blobReader(b))
+}
+
+// pinnable is implemented by Blobs whose backing storage can be
+// concurrently reclaimed out from under an in-progress read — currently
+// only fileBlob, via the spillFile it may still share with in-flight
+// coalesced followers. Reading such a Blob across a span that might pause
+// partway through (a slow client, a stale entry about to be overwritten)
+// requires pinning it first so a concurrent Close can't free the file
+// while the read is still using it.
+type pinnable interface {
+	pin()
+	unpin()
+}
+
+// pinBlob pins b for the duration of a read if it supports it, returning
+// the matching unpin to defer. Blobs that aren't shared with anything
+// else (memBlob, diskBlob) have nothing to protect and get a no-op.
+func pinBlob(b Blob) (unpin func()) {
+	if p, ok := b.(pinnable); ok {
+		p.pin()
+		return p.unpin
+	}
+	return func() {}
+}
+
+// finalizeSpill turns a fully-written spill file into a Blob: small
+// bodies are read into memory and the file is released, large ones keep
+// the file as a fileBlob.
+func finalizeSpill(sf *spillFile, size int64) (Blob, error) {
+	if size <= spillThreshold {
+		data := make([]byte, size)
+		if _, err := sf.file.ReadAt(data, 0); err != nil && err != io.EOF {
+			sf.release()
+			return nil, fmt.Errorf("failed to read spilled body: %w", err)
+		}
+		sf.release()
+		return memBlob(data), nil
+	}
+	return newFileBlob(sf, size), nil
+}
+
+// spillToBlob copies all of r into either memory (if small) or a spilled
+// temp file (if not), returning the resulting Blob.
+func spillToBlob(r io.Reader) (Blob, error) {
+	f, err := os.CreateTemp("", "httpcache-spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return finalizeSpill(newSpillFile(f), n)
+}
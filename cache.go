@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// defaultJanitorInterval is how often the background janitor sweeps the
+// cache for entries that have passed their ExpiresAt.
+const defaultJanitorInterval = 30 * time.Second
+
+// boundedCache is an in-memory Storage backend: a size- and
+// byte-accounted LRU cache of CacheEntry values. It evicts by LRU
+// recency once maxEntries or maxBytes is exceeded, and a background
+// janitor proactively removes entries whose freshness window has passed
+// rather than waiting for a lookup to find them stale.
+type boundedCache struct {
+	mu       sync.Mutex
+	lru      *lru.Cache[RequestKey, *CacheEntry]
+	maxBytes int64
+	bytes    int64
+
+	evictions uint64
+}
+
+// newBoundedCache builds a boundedCache capped at maxEntries items and
+// maxBytes of accounted response+header size (maxBytes <= 0 means no byte
+// cap), and starts its janitor goroutine.
+func newBoundedCache(maxEntries int, maxBytes int64, janitorInterval time.Duration) *boundedCache {
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	if janitorInterval <= 0 {
+		janitorInterval = defaultJanitorInterval
+	}
+
+	bc := &boundedCache{maxBytes: maxBytes}
+	c, err := lru.NewWithEvict[RequestKey, *CacheEntry](maxEntries, bc.onEvict)
+	if err != nil {
+		// maxEntries is always > 0 above, so this can't actually happen.
+		panic(err)
+	}
+	bc.lru = c
+
+	go bc.runJanitor(janitorInterval)
+	return bc
+}
+
+// entrySize estimates the bytes a CacheEntry occupies: its body plus its
+// header names and values.
+func entrySize(entry *CacheEntry) int64 {
+	size := entry.Response.Size()
+	for k, values := range entry.Headers {
+		size += int64(len(k))
+		for _, v := range values {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
+
+// onEvict runs whenever the underlying LRU drops a key, whether from
+// capacity pressure, an explicit Delete, or RemoveOldest. It keeps the
+// byte accounting and eviction counter in sync with the LRU's own view of
+// its contents, and releases the entry's Blob (e.g. removing a spilled
+// temp file) since nothing else references it once it's out of the LRU.
+func (bc *boundedCache) onEvict(_ RequestKey, entry *CacheEntry) {
+	atomic.AddInt64(&bc.bytes, -entrySize(entry))
+	atomic.AddUint64(&bc.evictions, 1)
+	entry.Response.Close()
+}
+
+func (bc *boundedCache) Get(key RequestKey) (*CacheEntry, bool) {
+	return bc.lru.Get(key)
+}
+
+// Set inserts entry, evicting by LRU recency until the cache is back
+// under its byte budget.
+func (bc *boundedCache) Set(key RequestKey, entry *CacheEntry) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// Add() silently overwrites an existing key without invoking onEvict,
+	// so the old entry's bytes have to be subtracted by hand here, and its
+	// Blob released unless it's the very same one the new entry reuses
+	// (as happens when a stale-but-valid entry is refreshed in place).
+	if old, ok := bc.lru.Peek(key); ok {
+		atomic.AddInt64(&bc.bytes, -entrySize(old))
+		if !sameBlob(old.Response, entry.Response) {
+			old.Response.Close()
+		}
+	}
+	bc.lru.Add(key, entry)
+	atomic.AddInt64(&bc.bytes, entrySize(entry))
+
+	for bc.maxBytes > 0 && atomic.LoadInt64(&bc.bytes) > bc.maxBytes {
+		if _, _, ok := bc.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+}
+
+func (bc *boundedCache) Delete(key RequestKey) {
+	bc.lru.Remove(key)
+}
+
+// Iterate visits every entry currently held, from oldest to newest.
+func (bc *boundedCache) Iterate(fn func(key RequestKey, entry *CacheEntry) bool) {
+	for _, key := range bc.lru.Keys() {
+		entry, ok := bc.lru.Peek(key)
+		if !ok {
+			continue
+		}
+		if !fn(key, entry) {
+			return
+		}
+	}
+}
+
+func (bc *boundedCache) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		bc.evictExpired()
+	}
+}
+
+func (bc *boundedCache) evictExpired() {
+	now := time.Now()
+	var expired []RequestKey
+	bc.Iterate(func(key RequestKey, entry *CacheEntry) bool {
+		if now.After(entry.ExpiresAt) {
+			expired = append(expired, key)
+		}
+		return true
+	})
+	for _, key := range expired {
+		bc.Delete(key)
+	}
+}
+
+func (bc *boundedCache) Stats() cacheStats {
+	return cacheStats{
+		Entries:   bc.lru.Len(),
+		Bytes:     atomic.LoadInt64(&bc.bytes),
+		Evictions: atomic.LoadUint64(&bc.evictions),
+	}
+}
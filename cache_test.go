@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func smallEntry(body string) *CacheEntry {
+	return &CacheEntry{
+		Response:   memBlob([]byte(body)),
+		Headers:    http.Header{},
+		StatusCode: 200,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+}
+
+// TestBoundedCacheEntryEviction ensures the LRU evicts the least recently
+// used entry once maxEntries is exceeded, and that Stats' entry/eviction
+// counts track it.
+func TestBoundedCacheEntryEviction(t *testing.T) {
+	bc := newBoundedCache(2, 0, time.Hour)
+
+	bc.Set(RequestKey{URL: "/a", Method: "GET"}, smallEntry("a"))
+	bc.Set(RequestKey{URL: "/b", Method: "GET"}, smallEntry("b"))
+
+	// Touch /a so /b becomes the least recently used.
+	if _, ok := bc.Get(RequestKey{URL: "/a", Method: "GET"}); !ok {
+		t.Fatalf("expected /a to be present")
+	}
+
+	bc.Set(RequestKey{URL: "/c", Method: "GET"}, smallEntry("c"))
+
+	if _, ok := bc.Get(RequestKey{URL: "/b", Method: "GET"}); ok {
+		t.Errorf("/b should have been evicted as the least recently used entry")
+	}
+	if _, ok := bc.Get(RequestKey{URL: "/a", Method: "GET"}); !ok {
+		t.Errorf("/a should still be present")
+	}
+
+	stats := bc.Stats()
+	if stats.Entries != 2 {
+		t.Errorf("Entries = %d, want 2", stats.Entries)
+	}
+	if stats.Evictions != 1 {
+		t.Errorf("Evictions = %d, want 1", stats.Evictions)
+	}
+}
+
+// TestBoundedCacheByteEviction ensures entries are evicted by LRU recency
+// once the accounted byte total exceeds maxBytes, even while still under
+// maxEntries.
+func TestBoundedCacheByteEviction(t *testing.T) {
+	bc := newBoundedCache(100, 10, time.Hour)
+
+	bc.Set(RequestKey{URL: "/a", Method: "GET"}, smallEntry("aaaaa")) // 5 bytes
+	bc.Set(RequestKey{URL: "/b", Method: "GET"}, smallEntry("bbbbb")) // 5 bytes, total 10
+
+	if got := bc.Stats().Bytes; got != 10 {
+		t.Fatalf("Bytes = %d, want 10", got)
+	}
+
+	// Pushes total to 15, over the 10-byte budget; /a (least recently used)
+	// must be evicted to bring it back under.
+	bc.Set(RequestKey{URL: "/c", Method: "GET"}, smallEntry("ccccc"))
+
+	if _, ok := bc.Get(RequestKey{URL: "/a", Method: "GET"}); ok {
+		t.Errorf("/a should have been evicted to stay under the byte budget")
+	}
+	if got := bc.Stats().Bytes; got != 10 {
+		t.Errorf("Bytes after eviction = %d, want 10", got)
+	}
+}
+
+// TestBoundedCacheEvictExpired ensures entries past their freshness window
+// are swept even without being looked up.
+func TestBoundedCacheEvictExpired(t *testing.T) {
+	bc := newBoundedCache(100, 0, time.Hour)
+
+	expired := smallEntry("stale")
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	bc.Set(RequestKey{URL: "/stale", Method: "GET"}, expired)
+	bc.Set(RequestKey{URL: "/fresh", Method: "GET"}, smallEntry("fresh"))
+
+	bc.evictExpired()
+
+	if _, ok := bc.Get(RequestKey{URL: "/stale", Method: "GET"}); ok {
+		t.Errorf("expired entry should have been evicted")
+	}
+	if _, ok := bc.Get(RequestKey{URL: "/fresh", Method: "GET"}); !ok {
+		t.Errorf("fresh entry should still be present")
+	}
+	if got := bc.Stats().Evictions; got != 1 {
+		t.Errorf("Evictions = %d, want 1", got)
+	}
+}
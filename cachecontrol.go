@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl holds the directives relevant to this proxy that were parsed
+// out of a Cache-Control header. A nil *int means the directive was absent.
+type cacheControl struct {
+	NoStore              bool
+	NoCache              bool
+	Private              bool
+	OnlyIfCached         bool
+	MaxAge               *int
+	SMaxAge              *int
+	StaleWhileRevalidate *int
+	StaleIfError         *int
+}
+
+// parseCacheControl parses a Cache-Control header value (request or response)
+// into the directives this proxy understands. Unknown directives are ignored.
+func parseCacheControl(header string) cacheControl {
+	var cc cacheControl
+	if header == "" {
+		return cc
+	}
+
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		switch name {
+		case "no-store":
+			cc.NoStore = true
+		case "no-cache":
+			cc.NoCache = true
+		case "private":
+			cc.Private = true
+		case "only-if-cached":
+			cc.OnlyIfCached = true
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				cc.MaxAge = &seconds
+			}
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				cc.SMaxAge = &seconds
+			}
+		case "stale-while-revalidate":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				cc.StaleWhileRevalidate = &seconds
+			}
+		case "stale-if-error":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				cc.StaleIfError = &seconds
+			}
+		}
+	}
+
+	return cc
+}
+
+// responseFreshness computes how long a response should be considered fresh
+// based on its Cache-Control and Expires headers, falling back to
+// defaultTTL when neither is present. The second return value reports
+// whether the response is cacheable at all (no-store/private responses are
+// not).
+func responseFreshness(headers http.Header, now time.Time, defaultTTL time.Duration) (time.Time, bool) {
+	cc := parseCacheControl(headers.Get("Cache-Control"))
+	if cc.NoStore || cc.Private {
+		return time.Time{}, false
+	}
+
+	// s-maxage takes precedence over max-age for shared caches like this one.
+	if cc.SMaxAge != nil {
+		return now.Add(time.Duration(*cc.SMaxAge) * time.Second), true
+	}
+	if cc.MaxAge != nil {
+		return now.Add(time.Duration(*cc.MaxAge) * time.Second), true
+	}
+	if cc.NoCache {
+		// Cacheable, but must be revalidated before every use.
+		return now, true
+	}
+
+	if expires := headers.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			return t, true
+		}
+	}
+
+	return now.Add(defaultTTL), true
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// RequestKey uniquely identifies a request based on URL, method, and (when
+// the upstream response declared a Vary header) a hash of the request
+// header values that response varies on.
+type RequestKey struct {
+	URL      string
+	Method   string
+	VaryHash string
+}
+
+// CacheEntry represents a cached response. Response is a Blob rather
+// than a raw []byte so that bodies too large to hold comfortably in
+// memory can be backed by a spilled temp file instead.
+type CacheEntry struct {
+	Response   Blob
+	Headers    http.Header
+	StatusCode int
+	ExpiresAt  time.Time
+}
+
+// InFlightRequest represents an ongoing request. HeadersReady closes as
+// soon as the upstream status/headers are known (or the request failed
+// before getting that far); Body is the growing spill file coalesced
+// followers stream from in parallel with the leader.
+type InFlightRequest struct {
+	HeadersReady chan struct{}
+	StatusCode   int
+	Headers      http.Header
+	Body         *growingFile
+	Error        error
+}
+
+// maxVaryIndexEntries bounds the varyIndex LRU, the same way the memory
+// cache backend bounds its own entries, so a long-running proxy that sees
+// many distinct Vary-varying URLs doesn't leak the index forever.
+const maxVaryIndexEntries = defaultMaxEntries
+
+type CacheServer struct {
+	client     *http.Client
+	cache      Storage
+	inFlight   sync.Map
+	varyIndex  *lru.Cache[string, []string] // varyIndexKey(string) -> []string of header names
+	cacheTTL   time.Duration
+	targetHost string
+
+	adminSecret       string
+	invalidationRules []invalidationRule
+
+	negativeTTL4xx       time.Duration
+	negativeTTL5xx       time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+
+	hits      uint64
+	misses    uint64
+	coalesced uint64
+}
+
+func NewCacheServer(cacheTTL time.Duration, targetHost string, cache Storage) *CacheServer {
+	varyIndex, err := lru.New[string, []string](maxVaryIndexEntries)
+	if err != nil {
+		// maxVaryIndexEntries is always > 0, so this can't actually happen.
+		panic(err)
+	}
+
+	return &CacheServer{
+		client:            &http.Client{},
+		cache:             cache,
+		varyIndex:         varyIndex,
+		cacheTTL:          cacheTTL,
+		targetHost:        targetHost,
+		adminSecret:       os.Getenv("CACHE_ADMIN_SECRET"),
+		invalidationRules: parseInvalidationRules(os.Getenv("CACHE_INVALIDATION_RULES")),
+
+		negativeTTL4xx:       envSeconds("CACHE_NEGATIVE_TTL_4XX", defaultNegativeTTL4xx),
+		negativeTTL5xx:       envSeconds("CACHE_NEGATIVE_TTL_5XX", 0),
+		staleWhileRevalidate: envSeconds("CACHE_STALE_WHILE_REVALIDATE", 0),
+		staleIfError:         envSeconds("CACHE_STALE_IF_ERROR", defaultStaleIfError),
+	}
+}
+
+// varyIndexKey identifies the Vary-header set previously observed for a
+// given URL+method, independent of any specific request's header values.
+func (cs *CacheServer) varyIndexKey(r *http.Request) string {
+	return r.Method + " " + r.URL.String()
+}
+
+// hashVaryHeaders hashes the request header values named in varyHeaders so
+// they can be folded into a RequestKey without storing the raw values.
+func hashVaryHeaders(r *http.Request, varyHeaders []string) string {
+	if len(varyHeaders) == 0 {
+		return ""
+	}
+
+	names := make([]string, len(varyHeaders))
+	copy(names, varyHeaders)
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", strings.ToLower(name), r.Header.Get(name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// parseVaryHeaderNames splits a Vary header value into individual header
+// names. A Vary of "*" matches nothing specific here; callers treat it as
+// if no index were available.
+func parseVaryHeaderNames(vary string) []string {
+	if vary == "" || vary == "*" {
+		return nil
+	}
+	parts := strings.Split(vary, ",")
+	names := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
+func (cs *CacheServer) getCacheKey(r *http.Request) RequestKey {
+	key := RequestKey{
+		URL:    r.URL.String(),
+		Method: r.Method,
+	}
+	if names, ok := cs.varyIndex.Get(cs.varyIndexKey(r)); ok {
+		key.VaryHash = hashVaryHeaders(r, names)
+	}
+	return key
+}
+
+// hasValidators reports whether headers carry a validator that allows a
+// conditional (If-None-Match / If-Modified-Since) revalidation request.
+func hasValidators(headers http.Header) bool {
+	return headers.Get("ETag") != "" || headers.Get("Last-Modified") != ""
+}
+
+// newUpstreamRequest builds the request that will be sent to the target
+// host for the given inbound request, copying headers over.
+func (cs *CacheServer) newUpstreamRequest(req *http.Request) (*http.Request, error) {
+	targetURL := cs.targetHost + req.URL.Path
+	if req.URL.RawQuery != "" {
+		targetURL += "?" + req.URL.RawQuery
+	}
+	log.Printf("Calling URL: %s", targetURL)
+
+	newReq, err := http.NewRequest(req.Method, targetURL, req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create target request: %w", err)
+	}
+
+	for key, values := range req.Header {
+		for _, value := range values {
+			newReq.Header.Add(key, value)
+		}
+	}
+
+	// Add Accept-Encoding header to handle gzip
+	newReq.Header.Set("Accept-Encoding", "gzip")
+
+	return newReq, nil
+}
+
+// decodeContentEncoding returns a reader over resp's body with any gzip
+// Content-Encoding transparently decoded, plus a copy of the response
+// headers with Content-Encoding and Content-Length stripped (the latter
+// because it describes the encoded, not decoded, body length and we may
+// not even know the final decoded length up front). Closing the returned
+// reader closes both the decoder and the underlying response body.
+func decodeContentEncoding(resp *http.Response) (io.ReadCloser, http.Header, error) {
+	headers := make(http.Header)
+	for k, v := range resp.Header {
+		headers[k] = v
+	}
+	headers.Del("Content-Encoding")
+	headers.Del("Content-Length")
+
+	if strings.ToLower(resp.Header.Get("Content-Encoding")) != "gzip" {
+		return resp.Body, headers, nil
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	return multiCloser{Reader: gz, closers: []io.Closer{gz, resp.Body}}, headers, nil
+}
+
+// multiCloser lets a decoded body reader (e.g. a *gzip.Reader) and the
+// underlying response body be closed together as one io.ReadCloser.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// parseResponse decodes an upstream response into a CacheEntry, computing
+// its freshness window from the response's Cache-Control/Expires headers.
+func (cs *CacheServer) parseResponse(resp *http.Response) (*CacheEntry, error) {
+	reader, headers, err := decodeContentEncoding(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	defer reader.Close()
+
+	blob, err := spillToBlob(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt, _ := responseFreshness(headers, time.Now(), cs.negativeTTLFor(resp.StatusCode))
+
+	return &CacheEntry{
+		Response:   blob,
+		Headers:    headers,
+		StatusCode: resp.StatusCode,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// isCacheable reports whether a freshly fetched response is allowed to be
+// stored at all (no-store/private responses never are).
+func isCacheable(headers http.Header) bool {
+	_, cacheable := responseFreshness(headers, time.Now(), 0)
+	return cacheable
+}
+
+// fetchAndStream fetches req's response from upstream into inFlight's
+// growing spill file, so coalesced followers can stream the same response
+// without waiting for it to finish. If w is non-nil, status/headers/body
+// are also streamed to it as they arrive; w is nil for a background
+// stale-while-revalidate refresh, which has no client connection of its
+// own. cacheStatus is the X-Cache value (and request-outcome counter) to
+// report to w, or "" if this fetch isn't a cache-related outcome at all
+// (a mutating request, which never consults the cache in the first
+// place). The returned CacheEntry (nil on error) is what the caller
+// should cache.
+func (cs *CacheServer) fetchAndStream(w http.ResponseWriter, req *http.Request, inFlight *InFlightRequest, cacheStatus string) (*CacheEntry, error) {
+	newReq, err := cs.newUpstreamRequest(req)
+	if err != nil {
+		inFlight.Error = err
+		close(inFlight.HeadersReady)
+		return nil, err
+	}
+
+	resp, err := cs.client.Do(newReq)
+	if err != nil {
+		inFlight.Error = fmt.Errorf("failed to make request: %w", err)
+		close(inFlight.HeadersReady)
+		return nil, inFlight.Error
+	}
+
+	return cs.streamResponse(w, resp, inFlight, cacheStatus)
+}
+
+// streamResponse tees resp's body simultaneously to inFlight's growing spill
+// file and, if w is non-nil, to the client, so neither coalesced followers
+// nor the original caller have to wait for the full body to download before
+// seeing bytes. It's the shared tail of fetchAndStream and revalidate's
+// changed-content path, which differ only in how the upstream request (and
+// therefore resp) gets built. See fetchAndStream for cacheStatus.
+func (cs *CacheServer) streamResponse(w http.ResponseWriter, resp *http.Response, inFlight *InFlightRequest, cacheStatus string) (*CacheEntry, error) {
+	reader, headers, err := decodeContentEncoding(resp)
+	if err != nil {
+		resp.Body.Close()
+		inFlight.Error = err
+		close(inFlight.HeadersReady)
+		return nil, err
+	}
+	defer reader.Close()
+
+	tmpFile, err := os.CreateTemp("", "httpcache-spill-*")
+	if err != nil {
+		inFlight.Error = fmt.Errorf("failed to create spill file: %w", err)
+		close(inFlight.HeadersReady)
+		return nil, inFlight.Error
+	}
+	gf := newGrowingFile(tmpFile)
+
+	inFlight.StatusCode = resp.StatusCode
+	inFlight.Headers = headers
+	inFlight.Body = gf
+	close(inFlight.HeadersReady)
+
+	var dst io.Writer = gf
+	if w != nil {
+		for k, v := range headers {
+			w.Header()[k] = v
+		}
+		if cacheStatus != "" {
+			cs.recordOutcome(cacheStatus)
+			w.Header().Set("X-Cache", cacheStatus)
+		}
+		w.WriteHeader(resp.StatusCode)
+		dst = io.MultiWriter(newFlushWriter(w), gf)
+	}
+
+	_, copyErr := io.Copy(dst, reader)
+	gf.Finish(copyErr)
+	if copyErr != nil {
+		gf.sf.release()
+		return nil, fmt.Errorf("failed to stream response body: %w", copyErr)
+	}
+
+	blob, err := finalizeSpill(gf.sf, gf.size)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt, _ := responseFreshness(headers, time.Now(), cs.negativeTTLFor(resp.StatusCode))
+	return &CacheEntry{
+		Response:   blob,
+		Headers:    headers,
+		StatusCode: resp.StatusCode,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// revalidate issues a conditional GET against upstream using the stored
+// entry's validators. notModified reports whether upstream returned 304,
+// in which case the returned entry reuses the old body with a refreshed
+// freshness window. Otherwise the resource actually changed, and the new
+// body is streamed straight to w as it downloads — the same teeing
+// fetchAndStream does for an ordinary cache miss — rather than fully
+// buffered first; streamed reports whether that happened (i.e. whether
+// headers have already gone out to w), which the caller needs to know
+// before it can still fall back to serving the stale entry on error.
+func (cs *CacheServer) revalidate(w http.ResponseWriter, req *http.Request, stale *CacheEntry) (updated *CacheEntry, notModified, streamed bool, err error) {
+	newReq, err := cs.newUpstreamRequest(req)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if etag := stale.Headers.Get("ETag"); etag != "" {
+		newReq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := stale.Headers.Get("Last-Modified"); lastModified != "" {
+		newReq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := cs.client.Do(newReq)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("failed to revalidate: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		refreshed := *stale
+		refreshed.ExpiresAt, _ = responseFreshness(resp.Header, time.Now(), cs.cacheTTL)
+		return &refreshed, true, false, nil
+	}
+
+	// A server error is worth giving stale-if-error a chance to mask, so it
+	// must not reach the client until the caller has made that call; buffer
+	// it fully instead of streaming.
+	if resp.StatusCode >= http.StatusInternalServerError {
+		fresh, err := cs.parseResponse(resp)
+		return fresh, false, false, err
+	}
+
+	inFlight := &InFlightRequest{HeadersReady: make(chan struct{})}
+	fresh, err := cs.streamResponse(w, resp, inFlight, "MISS")
+	return fresh, false, inFlight.Headers != nil, err
+}
+
+// recordOutcome folds a request's X-Cache status into the request-outcome
+// counters rendered on /metrics.
+func (cs *CacheServer) recordOutcome(cacheStatus string) {
+	switch cacheStatus {
+	case "HIT", "REVALIDATED", "STALE":
+		atomic.AddUint64(&cs.hits, 1)
+	case "MISS":
+		atomic.AddUint64(&cs.misses, 1)
+	case "COALESCED":
+		atomic.AddUint64(&cs.coalesced, 1)
+	}
+}
+
+// writeEntry writes entry as the HTTP response, tagging it with the
+// X-Cache status and recording that outcome.
+func (cs *CacheServer) writeEntry(w http.ResponseWriter, entry *CacheEntry, cacheStatus string) {
+	cs.recordOutcome(cacheStatus)
+
+	// Pin the body for the whole copy: entry may still be a fileBlob
+	// sharing its spill file with coalesced followers, and a concurrent
+	// eviction/overwrite of this same entry must not free it mid-stream.
+	unpin := pinBlob(entry.Response)
+	defer unpin()
+
+	for k, v := range entry.Headers {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", cacheStatus)
+	w.WriteHeader(entry.StatusCode)
+	io.Copy(w, blobReader(entry.Response))
+}
+
+func (cs *CacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// RFC 7234 only permits caching responses to methods "understood" as
+	// cacheable (GET/HEAD); a POST/PUT/PATCH/DELETE must reach upstream and
+	// execute every single time; it must never be looked up in the cache,
+	// stored into it, or coalesced with another in-flight request for the
+	// same URL — doing any of those would replay one write's response in
+	// place of actually performing a later one. These methods only ever
+	// interact with the cache as an invalidation trigger, after the fact.
+	if isMutatingMethod(r.Method) {
+		cs.serveMutating(w, r)
+		return
+	}
+
+	key := cs.getCacheKey(r)
+	log.Printf("Cache key: %+v", key)
+
+	reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+	forceRevalidate := reqCC.NoCache || (reqCC.MaxAge != nil && *reqCC.MaxAge == 0)
+
+	if cachedEntry, ok := cs.cache.Get(key); ok {
+		now := time.Now()
+		if !forceRevalidate && now.Before(cachedEntry.ExpiresAt) {
+			cs.writeEntry(w, cachedEntry, "HIT")
+			return
+		}
+
+		if reqCC.OnlyIfCached {
+			http.Error(w, "key not present in cache", http.StatusGatewayTimeout)
+			return
+		}
+
+		// Within the stale-while-revalidate grace window: serve the stale
+		// body immediately and kick off an async refresh for next time,
+		// rather than making this request wait on upstream.
+		if !forceRevalidate && now.Before(cachedEntry.ExpiresAt.Add(cs.staleWhileRevalidateWindow(cachedEntry))) {
+			cs.writeEntry(w, cachedEntry, "STALE")
+			cs.refreshInBackground(r, key)
+			return
+		}
+
+		if hasValidators(cachedEntry.Headers) {
+			updated, notModified, streamed, err := cs.revalidate(w, r, cachedEntry)
+			if err == nil && updated.StatusCode < http.StatusInternalServerError {
+				if notModified {
+					cs.writeEntry(w, updated, "REVALIDATED")
+				}
+				// Otherwise the changed body was already streamed straight
+				// to w by revalidate (streamed is always true here: the
+				// only case where it wouldn't be — a >=500 response — is
+				// excluded by the StatusCode check above).
+				cs.storeEntry(r, updated)
+				return
+			}
+
+			if streamed {
+				// Headers, and possibly a partial body, already reached the
+				// client via streamResponse; there's no going back to serve
+				// the stale entry instead, so just log the failure.
+				if err != nil {
+					log.Printf("failed to complete streamed revalidation: %v", err)
+				}
+				return
+			}
+
+			// The refresh failed outright or came back as a server error:
+			// stale-if-error serves what we already have, within its own
+			// grace window, rather than propagating the failure.
+			if now.Before(cachedEntry.ExpiresAt.Add(cs.staleIfErrorWindow(cachedEntry))) {
+				if updated != nil {
+					updated.Response.Close()
+				}
+				cs.writeEntry(w, cachedEntry, "STALE")
+				return
+			}
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			// No stale-if-error grace left: surface upstream's own error response.
+			cs.writeEntry(w, updated, "MISS")
+			cs.storeEntry(r, updated)
+			return
+		}
+
+		cs.cache.Delete(key)
+	} else if reqCC.OnlyIfCached {
+		http.Error(w, "key not present in cache", http.StatusGatewayTimeout)
+		return
+	}
+
+	// Check if there's an in-flight request
+	if inFlight, ok := cs.inFlight.Load(key); ok {
+		req := inFlight.(*InFlightRequest)
+		<-req.HeadersReady
+		if req.Error != nil {
+			http.Error(w, req.Error.Error(), http.StatusBadGateway)
+			return
+		}
+		cs.recordOutcome("COALESCED")
+		for k, v := range req.Headers {
+			w.Header()[k] = v
+		}
+		w.Header().Set("X-Cache", "COALESCED")
+		w.WriteHeader(req.StatusCode)
+		body := req.Body.NewReader()
+		io.Copy(w, body)
+		body.Close()
+		return
+	}
+
+	// Create new in-flight request
+	inFlight := &InFlightRequest{
+		HeadersReady: make(chan struct{}),
+	}
+	cs.inFlight.Store(key, inFlight)
+	defer cs.inFlight.Delete(key)
+
+	// Clone the request body if it exists
+	var bodyBytes []byte
+	if r.Body != nil {
+		bodyBytes, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	}
+
+	entry, err := cs.fetchAndStream(w, r, inFlight, "MISS")
+	if err != nil {
+		if inFlight.Headers == nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		} else {
+			log.Printf("failed to complete streamed response: %v", err)
+		}
+		return
+	}
+
+	cs.storeEntry(r, entry)
+}
+
+// serveMutating handles a POST/PUT/PATCH/DELETE request: it always goes
+// straight to upstream — never consulting the cache, coalescing with
+// another in-flight request, or storing its own response — and, once
+// successful, invalidates whatever GET entries it affects.
+func (cs *CacheServer) serveMutating(w http.ResponseWriter, r *http.Request) {
+	// Clone the request body if it exists, the same as the GET/HEAD miss
+	// path below: fetchAndStream consumes r.Body, but newUpstreamRequest
+	// still needs it intact to build the actual upstream request from.
+	var bodyBytes []byte
+	if r.Body != nil {
+		bodyBytes, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	}
+
+	inFlight := &InFlightRequest{HeadersReady: make(chan struct{})}
+	entry, err := cs.fetchAndStream(w, r, inFlight, "")
+	if err != nil {
+		if inFlight.Headers == nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		} else {
+			log.Printf("failed to complete streamed response: %v", err)
+			// Upstream already executed the write and reported a status —
+			// relaying the body back to the client failed, but the write
+			// itself isn't undone, so any affected GET entries still need
+			// invalidating.
+			if inFlight.StatusCode >= 200 && inFlight.StatusCode < 300 {
+				cs.invalidateForRequest(r)
+			}
+		}
+		return
+	}
+	// Never cached, so nothing else will ever reference this entry's body.
+	defer entry.Response.Close()
+
+	if entry.StatusCode >= 200 && entry.StatusCode < 300 {
+		cs.invalidateForRequest(r)
+	}
+}
+
+// storeEntry caches entry under the key appropriate for r, updating the
+// Vary index first so the key reflects any Vary header the response just
+// declared. Responses marked no-store/private are not cached, and their
+// Blob is released immediately since nothing else will reference it.
+func (cs *CacheServer) storeEntry(r *http.Request, entry *CacheEntry) {
+	if !isCacheable(entry.Headers) {
+		entry.Response.Close()
+		return
+	}
+
+	if names := parseVaryHeaderNames(entry.Headers.Get("Vary")); len(names) > 0 {
+		cs.varyIndex.Add(cs.varyIndexKey(r), names)
+	}
+
+	cs.cache.Set(cs.getCacheKey(r), entry)
+}
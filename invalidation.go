@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// mutatingMethods are the HTTP methods treated as non-idempotent writes: a
+// successful request with one of these invalidates cached reads affected
+// by it.
+var mutatingMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+func isMutatingMethod(method string) bool {
+	return mutatingMethods[method]
+}
+
+// invalidationRule extends a write's automatic self-invalidation to an
+// additional prefix — e.g. a write under "/posts/*" should also drop the
+// cached "/posts" listing. matchPrefix is a plain path prefix, optionally
+// ending in "*" to make the trailing wildcard explicit in config; it isn't
+// a general glob.
+type invalidationRule struct {
+	matchPrefix string
+	purgePrefix string
+}
+
+func (rule invalidationRule) matches(path string) bool {
+	return pathUnder(path, strings.TrimSuffix(rule.matchPrefix, "*"))
+}
+
+// parseInvalidationRules parses CACHE_INVALIDATION_RULES, a comma-separated
+// list of "matchPrefix=>purgePrefix" pairs.
+func parseInvalidationRules(s string) []invalidationRule {
+	var rules []invalidationRule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		halves := strings.SplitN(part, "=>", 2)
+		if len(halves) != 2 {
+			continue
+		}
+		rules = append(rules, invalidationRule{
+			matchPrefix: strings.TrimSpace(halves[0]),
+			purgePrefix: strings.TrimSpace(halves[1]),
+		})
+	}
+	return rules
+}
+
+// pathUnder reports whether path is prefix itself or a path segment below
+// it, so that a prefix of "/posts" matches "/posts" and "/posts/5" but not
+// "/posts2".
+func pathUnder(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// requestKeyPath extracts the URL path a RequestKey was cached under.
+func requestKeyPath(key RequestKey) string {
+	u, err := url.Parse(key.URL)
+	if err != nil {
+		return key.URL
+	}
+	return u.Path
+}
+
+// purgeMatching deletes every cached entry whose key satisfies match,
+// returning the number removed. Storage has no bulk-delete primitive, so
+// matching keys are collected via Iterate first rather than deleting while
+// iterating.
+func (cs *CacheServer) purgeMatching(match func(RequestKey) bool) int {
+	var keys []RequestKey
+	cs.cache.Iterate(func(key RequestKey, _ *CacheEntry) bool {
+		if match(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		cs.cache.Delete(key)
+	}
+	return len(keys)
+}
+
+// purgeUnder deletes every cached entry whose URL path is prefix or a path
+// segment below it.
+func (cs *CacheServer) purgeUnder(prefix string) int {
+	return cs.purgeMatching(func(key RequestKey) bool {
+		return pathUnder(requestKeyPath(key), prefix)
+	})
+}
+
+// purgeAll empties the cache entirely.
+func (cs *CacheServer) purgeAll() int {
+	return cs.purgeMatching(func(RequestKey) bool { return true })
+}
+
+// invalidateForRequest purges cached reads affected by a successful
+// mutating request: always the written URL's own path (and anything
+// nested under it), plus any configured rule whose matchPrefix the path
+// satisfies.
+func (cs *CacheServer) invalidateForRequest(r *http.Request) {
+	path := r.URL.Path
+	cs.purgeUnder(path)
+	for _, rule := range cs.invalidationRules {
+		if rule.matches(path) {
+			cs.purgeUnder(rule.purgePrefix)
+		}
+	}
+}
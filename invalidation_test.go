@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMutatingRequestsAreNeverCachedOrReplayed guards against a write's
+// response being cached and replayed as an X-Cache: HIT on a later,
+// identical write — each one must actually reach upstream and execute.
+func TestMutatingRequestsAreNeverCachedOrReplayed(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(w, "order-%d", n)
+	}))
+	defer upstream.Close()
+
+	cs := NewCacheServer(time.Minute, upstream.URL, newBoundedCache(100, 0, time.Hour))
+
+	for i := int32(1); i <= 3; i++ {
+		rec := httptest.NewRecorder()
+		cs.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+		want := fmt.Sprintf("order-%d", i)
+		if got := rec.Body.String(); got != want {
+			t.Errorf("POST #%d: got body %q, want %q (response was replayed instead of reaching upstream)", i, got, want)
+		}
+		if cache := rec.Header().Get("X-Cache"); cache == "HIT" {
+			t.Errorf("POST #%d: X-Cache was HIT, a write must never be served from cache", i)
+		}
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("upstream was called %d times, want 3", got)
+	}
+}
+
+// failAfterWriter is an http.ResponseWriter that fails every Write once
+// more than allow bytes have been written through it in total, simulating
+// a client that disconnects partway through the response body.
+type failAfterWriter struct {
+	http.ResponseWriter
+	allow   int
+	written int
+}
+
+func (f *failAfterWriter) Write(p []byte) (int, error) {
+	if f.written >= f.allow {
+		return 0, fmt.Errorf("simulated write failure")
+	}
+	if f.written+len(p) > f.allow {
+		p = p[:f.allow-f.written]
+	}
+	n, err := f.ResponseWriter.Write(p)
+	f.written += n
+	return n, err
+}
+
+func (f *failAfterWriter) Flush() {
+	if fl, ok := f.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// TestMutatingRequestInvalidatesEvenIfClientDisconnectsMidBody ensures a
+// write that upstream already executed still invalidates affected GET
+// entries even when relaying its response body back to the client fails
+// partway through — the write happened regardless of whether the client
+// stuck around to see the result.
+func TestMutatingRequestInvalidatesEvenIfClientDisconnectsMidBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			fmt.Fprint(w, "a very long confirmation body that will not fully arrive")
+			return
+		}
+		fmt.Fprint(w, "cached-value")
+	}))
+	defer upstream.Close()
+
+	cs := NewCacheServer(time.Minute, upstream.URL, newBoundedCache(100, 0, time.Hour))
+
+	rec := httptest.NewRecorder()
+	cs.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/widget", nil))
+	if got, want := rec.Body.String(), "cached-value"; got != want {
+		t.Fatalf("initial GET: got %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	failing := &failAfterWriter{ResponseWriter: rec, allow: 4}
+	cs.ServeHTTP(failing, httptest.NewRequest(http.MethodPost, "/widget", nil))
+
+	rec2 := httptest.NewRecorder()
+	cs.ServeHTTP(rec2, httptest.NewRequest(http.MethodGet, "/widget", nil))
+	if cache := rec2.Header().Get("X-Cache"); cache == "HIT" {
+		t.Errorf("GET after write was X-Cache: HIT, want a fresh fetch since the write should have invalidated it")
+	}
+}
+
+// TestWriteInvalidatesCachedRead ensures a successful write's invalidation
+// actually takes effect: a cached GET must be refreshed, not replayed,
+// after a write to the same resource.
+func TestWriteInvalidatesCachedRead(t *testing.T) {
+	var value int32 = 1
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&value, 1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fmt.Fprintf(w, "value-%d", atomic.LoadInt32(&value))
+	}))
+	defer upstream.Close()
+
+	cs := NewCacheServer(time.Minute, upstream.URL, newBoundedCache(100, 0, time.Hour))
+
+	get := func() string {
+		rec := httptest.NewRecorder()
+		cs.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/counter", nil))
+		return rec.Body.String()
+	}
+
+	if got, want := get(), "value-1"; got != want {
+		t.Fatalf("initial GET: got %q, want %q", got, want)
+	}
+	if got, want := get(), "value-1"; got != want {
+		t.Fatalf("second GET (should be served from cache): got %q, want %q", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	cs.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/counter", nil))
+
+	if got, want := get(), "value-2"; got != want {
+		t.Errorf("GET after write: got %q, want %q (invalidation didn't take effect)", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// requestStats is a point-in-time snapshot of the request-outcome
+// counters the CacheServer tracks itself, independent of whichever
+// Storage backend is in use.
+type requestStats struct {
+	Hits      uint64
+	Misses    uint64
+	Coalesced uint64
+}
+
+// HitRatio returns the cumulative hit ratio, or 0 if there have been no
+// lookups yet.
+func (s requestStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+func (cs *CacheServer) requestStats() requestStats {
+	return requestStats{
+		Hits:      atomic.LoadUint64(&cs.hits),
+		Misses:    atomic.LoadUint64(&cs.misses),
+		Coalesced: atomic.LoadUint64(&cs.coalesced),
+	}
+}
+
+// MetricsHandler serves Prometheus-style counters and gauges for the
+// cache on a path separate from the proxying ServeHTTP, so scraping it
+// never competes with or shows up in proxied request logs.
+func (cs *CacheServer) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	rs := cs.requestStats()
+
+	var ss cacheStats
+	if statser, ok := cs.cache.(StorageStats); ok {
+		ss = statser.Stats()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP cache_hits_total Cache lookups that found a usable entry.\n")
+	fmt.Fprintf(w, "# TYPE cache_hits_total counter\n")
+	fmt.Fprintf(w, "cache_hits_total %d\n", rs.Hits)
+
+	fmt.Fprintf(w, "# HELP cache_misses_total Cache lookups that required a fetch from upstream.\n")
+	fmt.Fprintf(w, "# TYPE cache_misses_total counter\n")
+	fmt.Fprintf(w, "cache_misses_total %d\n", rs.Misses)
+
+	fmt.Fprintf(w, "# HELP cache_coalesced_total Requests served by joining an in-flight fetch instead of starting a new one.\n")
+	fmt.Fprintf(w, "# TYPE cache_coalesced_total counter\n")
+	fmt.Fprintf(w, "cache_coalesced_total %d\n", rs.Coalesced)
+
+	fmt.Fprintf(w, "# HELP cache_evictions_total Entries removed from the cache, whether by capacity pressure or expiry. Zero if the backend doesn't report it.\n")
+	fmt.Fprintf(w, "# TYPE cache_evictions_total counter\n")
+	fmt.Fprintf(w, "cache_evictions_total %d\n", ss.Evictions)
+
+	fmt.Fprintf(w, "# HELP cache_entries Current number of entries held in the cache. Zero if the backend doesn't report it.\n")
+	fmt.Fprintf(w, "# TYPE cache_entries gauge\n")
+	fmt.Fprintf(w, "cache_entries %d\n", ss.Entries)
+
+	fmt.Fprintf(w, "# HELP cache_bytes Current accounted size of cached responses and headers. Zero if the backend doesn't report it.\n")
+	fmt.Fprintf(w, "# TYPE cache_bytes gauge\n")
+	fmt.Fprintf(w, "cache_bytes %d\n", ss.Bytes)
+
+	fmt.Fprintf(w, "# HELP cache_hit_ratio Cumulative hits / (hits + misses).\n")
+	fmt.Fprintf(w, "# TYPE cache_hit_ratio gauge\n")
+	fmt.Fprintf(w, "cache_hit_ratio %f\n", rs.HitRatio())
+}
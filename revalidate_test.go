@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRevalidateStreamsChangedBody ensures that when a stale entry's
+// revalidation comes back with a changed (non-304) body, the client sees
+// status/headers as soon as they're known rather than only after the whole
+// new body has finished downloading from upstream.
+func TestRevalidateStreamsChangedBody(t *testing.T) {
+	release := make(chan struct{})
+	var etag = "v1"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == "" {
+			w.Header().Set("ETag", etag)
+			fmt.Fprint(w, "first-version")
+			return
+		}
+
+		// Simulate the resource having changed: respond 200 with a new body,
+		// but hold the rest of it back until the test has had a chance to
+		// observe that headers already reached the client.
+		etag = "v2"
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, "second-")
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		<-release
+		fmt.Fprint(w, "version")
+	}))
+	defer upstream.Close()
+
+	cs := NewCacheServer(0, upstream.URL, newBoundedCache(100, 0, time.Hour))
+
+	// Prime the cache with an entry that's already expired (cacheTTL=0
+	// above) but has a validator, so the next GET takes the revalidate path.
+	rec := httptest.NewRecorder()
+	cs.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/thing", nil))
+	if got, want := rec.Body.String(), "first-version"; got != want {
+		t.Fatalf("initial GET: got %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		cs.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/thing", nil))
+		close(done)
+	}()
+
+	// Give the handler a chance to reach upstream and receive the first
+	// chunk; headers should already be on the recorder well before the
+	// upstream handler is released to finish the body.
+	deadline := time.After(time.Second)
+	for rec.Result().StatusCode == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("status was never written before the upstream body finished")
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+	select {
+	case <-done:
+		t.Fatal("request completed before upstream released the rest of the body")
+	default:
+	}
+
+	close(release)
+	<-done
+
+	if got, want := rec.Body.String(), "second-version"; got != want {
+		t.Errorf("revalidated GET: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultNegativeTTL4xx is how long a 404/410 is cached by default when
+// upstream didn't say otherwise. defaultStaleIfError mirrors
+// redisStaleGrace: a day is generous enough that a prolonged upstream
+// outage doesn't mean every request fails, without caching a bad response
+// forever.
+const (
+	defaultNegativeTTL4xx = 30 * time.Second
+	defaultStaleIfError   = 24 * time.Hour
+)
+
+// envSeconds reads key as a whole number of seconds, returning fallback if
+// it's unset or invalid.
+func envSeconds(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return fallback
+}
+
+// negativeTTLFor chooses the default freshness window for a response with
+// the given status code when upstream left Cache-Control/Expires
+// unspecified: negativeTTL4xx for 404/410, negativeTTL5xx for 5xx (0
+// disables negative caching for them, per RFC7234's spirit of not hiding
+// server errors), and the regular cacheTTL for everything else.
+func (cs *CacheServer) negativeTTLFor(statusCode int) time.Duration {
+	switch {
+	case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+		return cs.negativeTTL4xx
+	case statusCode >= http.StatusInternalServerError:
+		return cs.negativeTTL5xx
+	default:
+		return cs.cacheTTL
+	}
+}
+
+// staleWhileRevalidateWindow reports how long past ExpiresAt entry may
+// still be served while a refresh happens in the background: the
+// response's own stale-while-revalidate directive if it set one,
+// otherwise the server-wide default.
+func (cs *CacheServer) staleWhileRevalidateWindow(entry *CacheEntry) time.Duration {
+	cc := parseCacheControl(entry.Headers.Get("Cache-Control"))
+	if cc.StaleWhileRevalidate != nil {
+		return time.Duration(*cc.StaleWhileRevalidate) * time.Second
+	}
+	return cs.staleWhileRevalidate
+}
+
+// staleIfErrorWindow reports how long past ExpiresAt entry may still be
+// served if refreshing it fails, mirroring staleWhileRevalidateWindow.
+func (cs *CacheServer) staleIfErrorWindow(entry *CacheEntry) time.Duration {
+	cc := parseCacheControl(entry.Headers.Get("Cache-Control"))
+	if cc.StaleIfError != nil {
+		return time.Duration(*cc.StaleIfError) * time.Second
+	}
+	return cs.staleIfError
+}
+
+// snapshotRequest captures r's method, URL, headers, and a drained copy of
+// its body into a new, independent *http.Request. net/http closes (and may
+// fully drain) the original request's Body the moment ServeHTTP returns, so
+// a background refresh that outlives the handler can't safely read r itself
+// — it must work from a snapshot taken before returning.
+func snapshotRequest(r *http.Request) (*http.Request, error) {
+	snapshot := r.Clone(context.Background())
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		snapshot.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return snapshot, nil
+}
+
+// refreshInBackground kicks off an async upstream refresh for key, unless
+// one is already running — it shares cs.inFlight with the foreground miss
+// path, so a concurrent request for the same key coalesces onto whichever
+// of the two got there first instead of firing a second upstream request.
+func (cs *CacheServer) refreshInBackground(r *http.Request, key RequestKey) {
+	inFlight := &InFlightRequest{HeadersReady: make(chan struct{})}
+	if _, loaded := cs.inFlight.LoadOrStore(key, inFlight); loaded {
+		return
+	}
+
+	snapshot, err := snapshotRequest(r)
+	if err != nil {
+		log.Printf("stale-while-revalidate refresh failed for %s %s: %v", key.Method, key.URL, err)
+		cs.inFlight.Delete(key)
+		return
+	}
+
+	go func() {
+		defer cs.inFlight.Delete(key)
+
+		entry, err := cs.fetchAndStream(nil, snapshot, inFlight, "MISS")
+		if err != nil {
+			log.Printf("stale-while-revalidate refresh failed for %s %s: %v", key.Method, key.URL, err)
+			return
+		}
+		cs.storeEntry(snapshot, entry)
+	}()
+}
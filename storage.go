@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Storage is the persistence interface cache backends implement. Get,
+// Set, and Delete behave like a typical key/value store; Iterate visits
+// every entry currently held, stopping early if fn returns false.
+//
+// Implementations are responsible for their own concurrency safety.
+type Storage interface {
+	Get(key RequestKey) (*CacheEntry, bool)
+	Set(key RequestKey, entry *CacheEntry)
+	Delete(key RequestKey)
+	Iterate(fn func(key RequestKey, entry *CacheEntry) bool)
+}
+
+// StorageStats is implemented by backends that can report point-in-time
+// size/eviction metrics. Backends for which that doesn't make sense (a
+// shared Redis instance, say) simply don't implement it and are skipped
+// when rendering /metrics.
+type StorageStats interface {
+	Stats() cacheStats
+}
+
+// cacheStats is a point-in-time snapshot of a Storage backend's own
+// bookkeeping, as opposed to the request-outcome counters (hits, misses,
+// coalesced) the CacheServer tracks itself.
+type cacheStats struct {
+	Entries   int
+	Bytes     int64
+	Evictions uint64
+}
+
+// defaultMaxEntries and defaultMaxBytes bound the in-memory backend when
+// the corresponding env vars are unset or invalid.
+const (
+	defaultMaxEntries = 10000
+	defaultMaxBytes   = 256 * 1024 * 1024 // 256 MiB
+)
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envInt64(key string, fallback int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+// newStorageFromEnv builds the Storage backend selected by CACHE_BACKEND
+// (memory|disk|redis, default memory), reading the rest of its tuning
+// from env vars: CACHE_MAX_ENTRIES/CACHE_MAX_BYTES for memory and disk,
+// CACHE_DIR for disk, REDIS_URL for redis.
+func newStorageFromEnv() (Storage, error) {
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "", "memory":
+		maxEntries := envInt("CACHE_MAX_ENTRIES", defaultMaxEntries)
+		maxBytes := envInt64("CACHE_MAX_BYTES", defaultMaxBytes)
+		return newBoundedCache(maxEntries, maxBytes, defaultJanitorInterval), nil
+	case "disk":
+		maxEntries := envInt("CACHE_MAX_ENTRIES", defaultMaxEntries)
+		maxBytes := envInt64("CACHE_MAX_BYTES", defaultMaxBytes)
+		return newDiskStorage(os.Getenv("CACHE_DIR"), maxEntries, maxBytes, defaultJanitorInterval)
+	case "redis":
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL is required when CACHE_BACKEND=redis")
+		}
+		return newRedisStorage(redisURL)
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q (want memory, disk, or redis)", backend)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// diskBlob is a Blob backed by an open read-only handle onto a
+// persistent cache file. Unlike fileBlob, Close doesn't remove the file
+// — the file is the store's own persistence, not a scratch spill, and
+// is only ever removed via diskStorage.Delete.
+type diskBlob struct {
+	file *os.File
+	size int64
+}
+
+func (b *diskBlob) ReadAt(p []byte, off int64) (int, error) { return b.file.ReadAt(p, off) }
+func (b *diskBlob) Size() int64                             { return b.size }
+func (b *diskBlob) Close() error                            { return b.file.Close() }
+
+// cacheDirPlaceholder mirrors Hugo's file-cache convention: a CACHE_DIR
+// value containing it is expanded against the OS cache directory, so
+// deployments can say ":cacheDir/http-cache-middleware" instead of
+// hardcoding a platform-specific path.
+const cacheDirPlaceholder = ":cacheDir"
+
+// resolveCacheDir expands a leading cacheDirPlaceholder in dir against
+// os.UserCacheDir(), defaulting dir itself when empty.
+func resolveCacheDir(dir string) (string, error) {
+	if dir == "" {
+		dir = filepath.Join(cacheDirPlaceholder, "http-cache-middleware")
+	}
+	if strings.Contains(dir, cacheDirPlaceholder) {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", cacheDirPlaceholder, err)
+		}
+		dir = strings.Replace(dir, cacheDirPlaceholder, base, 1)
+	}
+	return dir, nil
+}
+
+// diskMeta is the small index file written alongside each cached body,
+// holding everything needed to serve or expire the entry without
+// touching the (potentially large) body file.
+type diskMeta struct {
+	Key        RequestKey
+	Headers    http.Header
+	StatusCode int
+	ExpiresAt  time.Time
+}
+
+// diskStorage is a Storage backend that persists each CacheEntry as a
+// content-addressed body file plus a JSON index file under dir, so the
+// cache survives restarts and can be shared between replicas over a
+// common volume. Like the in-memory backend it's bounded by maxEntries
+// and maxBytes (enforced on every Set by evicting the least recently
+// written entries first) and proactively prunes naturally-expired
+// entries via a background janitor, rather than growing without limit
+// until something happens to re-request a given key.
+type diskStorage struct {
+	dir        string
+	maxEntries int
+	maxBytes   int64
+
+	evictions uint64
+}
+
+// newDiskStorage builds a diskStorage rooted at dir, capped at maxEntries
+// items and maxBytes of body size (maxBytes <= 0 means no byte cap), and
+// starts its janitor goroutine.
+func newDiskStorage(dir string, maxEntries int, maxBytes int64, janitorInterval time.Duration) (*diskStorage, error) {
+	dir, err := resolveCacheDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir %q: %w", dir, err)
+	}
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	if janitorInterval <= 0 {
+		janitorInterval = defaultJanitorInterval
+	}
+
+	ds := &diskStorage{dir: dir, maxEntries: maxEntries, maxBytes: maxBytes}
+	go ds.runJanitor(janitorInterval)
+	return ds, nil
+}
+
+func diskKeyHash(key RequestKey) string {
+	h := sha256.Sum256([]byte(key.Method + "\x00" + key.URL + "\x00" + key.VaryHash))
+	return hex.EncodeToString(h[:])
+}
+
+func (ds *diskStorage) paths(key RequestKey) (bodyPath, metaPath string) {
+	hash := diskKeyHash(key)
+	return filepath.Join(ds.dir, hash+".body"), filepath.Join(ds.dir, hash+".json")
+}
+
+// readDiskMeta loads and decodes the index file at path without touching
+// the (potentially large) body it describes, so callers that only need an
+// entry's metadata — the janitor checking ExpiresAt, Iterate reporting a
+// key — don't pay for reading bodies they won't use.
+func readDiskMeta(path string) (diskMeta, bool) {
+	metaBytes, err := os.ReadFile(path)
+	if err != nil {
+		return diskMeta{}, false
+	}
+	var meta diskMeta
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return diskMeta{}, false
+	}
+	return meta, true
+}
+
+func (ds *diskStorage) Get(key RequestKey) (*CacheEntry, bool) {
+	bodyPath, metaPath := ds.paths(key)
+
+	meta, ok := readDiskMeta(metaPath)
+	if !ok {
+		return nil, false
+	}
+
+	f, err := os.Open(bodyPath)
+	if err != nil {
+		// Orphaned index with no body (e.g. interrupted write); drop it.
+		os.Remove(metaPath)
+		return nil, false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false
+	}
+
+	return &CacheEntry{
+		Response:   &diskBlob{file: f, size: info.Size()},
+		Headers:    meta.Headers,
+		StatusCode: meta.StatusCode,
+		ExpiresAt:  meta.ExpiresAt,
+	}, true
+}
+
+// Set persists entry under key. The body is written to a temp file and
+// renamed into place so a reader with bodyPath already open (as Get
+// returns) keeps seeing a complete, unmodified file even if entry.Response
+// happens to be backed by that very same path (as when revalidation
+// refreshes an entry's freshness window without changing its body).
+func (ds *diskStorage) Set(key RequestKey, entry *CacheEntry) {
+	// Set is always the last thing done with entry.Response (callers write
+	// the response to the client, if any, before persisting), so it owns
+	// closing it once the body has been copied out to bodyPath.
+	defer entry.Response.Close()
+
+	bodyPath, metaPath := ds.paths(key)
+
+	tmp, err := os.CreateTemp(ds.dir, "body-*.tmp")
+	if err != nil {
+		return
+	}
+	if _, err := io.Copy(tmp, blobReader(entry.Response)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	tmp.Close()
+	// Body is written (and renamed into place) before its index so Get
+	// never finds a meta file pointing at a body that doesn't exist yet.
+	if err := os.Rename(tmp.Name(), bodyPath); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	meta := diskMeta{
+		Key:        key,
+		Headers:    entry.Headers,
+		StatusCode: entry.StatusCode,
+		ExpiresAt:  entry.ExpiresAt,
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return
+	}
+	os.WriteFile(metaPath, metaBytes, 0o644)
+
+	ds.enforceLimits()
+}
+
+func (ds *diskStorage) Delete(key RequestKey) {
+	bodyPath, metaPath := ds.paths(key)
+	os.Remove(bodyPath)
+	os.Remove(metaPath)
+}
+
+// Iterate visits every entry on disk by reading back its index file.
+func (ds *diskStorage) Iterate(fn func(key RequestKey, entry *CacheEntry) bool) {
+	entries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return
+	}
+
+	for _, de := range entries {
+		name := de.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		meta, ok := readDiskMeta(filepath.Join(ds.dir, name))
+		if !ok {
+			continue
+		}
+
+		body, err := os.ReadFile(filepath.Join(ds.dir, strings.TrimSuffix(name, ".json")+".body"))
+		if err != nil {
+			continue
+		}
+
+		entry := &CacheEntry{
+			Response:   memBlob(body),
+			Headers:    meta.Headers,
+			StatusCode: meta.StatusCode,
+			ExpiresAt:  meta.ExpiresAt,
+		}
+		if !fn(meta.Key, entry) {
+			return
+		}
+	}
+}
+
+// diskBodyInfo is a body file's on-disk footprint, used to decide what to
+// evict when the store is over its entry or byte budget.
+type diskBodyInfo struct {
+	key      RequestKey
+	bodyPath string
+	metaPath string
+	size     int64
+	modTime  time.Time
+}
+
+// listBodies stats every body file under dir alongside the meta file that
+// describes it, skipping any whose meta is missing or unreadable.
+func (ds *diskStorage) listBodies() ([]diskBodyInfo, error) {
+	dirEntries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var bodies []diskBodyInfo
+	for _, de := range dirEntries {
+		name := de.Name()
+		if !strings.HasSuffix(name, ".body") {
+			continue
+		}
+
+		bodyPath := filepath.Join(ds.dir, name)
+		metaPath := filepath.Join(ds.dir, strings.TrimSuffix(name, ".body")+".json")
+		meta, ok := readDiskMeta(metaPath)
+		if !ok {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+
+		bodies = append(bodies, diskBodyInfo{
+			key:      meta.Key,
+			bodyPath: bodyPath,
+			metaPath: metaPath,
+			size:     info.Size(),
+			modTime:  info.ModTime(),
+		})
+	}
+	return bodies, nil
+}
+
+// enforceLimits evicts the least recently written entries (oldest body
+// mtime first) until the store is back under maxEntries and maxBytes.
+// There's no access-time bump on Get, so "least recently written" is this
+// backend's analogue of the in-memory store's LRU recency.
+func (ds *diskStorage) enforceLimits() {
+	if ds.maxEntries <= 0 && ds.maxBytes <= 0 {
+		return
+	}
+
+	bodies, err := ds.listBodies()
+	if err != nil {
+		return
+	}
+	sort.Slice(bodies, func(i, j int) bool { return bodies[i].modTime.Before(bodies[j].modTime) })
+
+	var total int64
+	for _, b := range bodies {
+		total += b.size
+	}
+
+	remaining := len(bodies)
+	for i := 0; i < len(bodies); i++ {
+		overEntries := ds.maxEntries > 0 && remaining > ds.maxEntries
+		overBytes := ds.maxBytes > 0 && total > ds.maxBytes
+		if !overEntries && !overBytes {
+			break
+		}
+		os.Remove(bodies[i].bodyPath)
+		os.Remove(bodies[i].metaPath)
+		total -= bodies[i].size
+		remaining--
+		atomic.AddUint64(&ds.evictions, 1)
+	}
+}
+
+func (ds *diskStorage) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ds.evictExpired()
+	}
+}
+
+// evictExpired removes entries whose freshness window has passed without
+// waiting for a lookup to notice — otherwise a body nobody re-requests
+// sits on disk forever even though it's long stale.
+func (ds *diskStorage) evictExpired() {
+	dirEntries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, de := range dirEntries {
+		name := de.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		metaPath := filepath.Join(ds.dir, name)
+		meta, ok := readDiskMeta(metaPath)
+		if !ok {
+			continue
+		}
+		if now.After(meta.ExpiresAt) {
+			ds.Delete(meta.Key)
+			atomic.AddUint64(&ds.evictions, 1)
+		}
+	}
+}
+
+// Stats reports the disk backend's current footprint by re-statting every
+// body file under dir, the same accounting enforceLimits already does to
+// decide what to evict.
+func (ds *diskStorage) Stats() cacheStats {
+	bodies, err := ds.listBodies()
+	if err != nil {
+		return cacheStats{}
+	}
+
+	var bytes int64
+	for _, b := range bodies {
+		bytes += b.size
+	}
+
+	return cacheStats{
+		Entries:   len(bodies),
+		Bytes:     bytes,
+		Evictions: atomic.LoadUint64(&ds.evictions),
+	}
+}
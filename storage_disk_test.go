@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDiskStorageStats ensures the disk backend reports its own entry
+// count, byte footprint, and eviction count through StorageStats, the same
+// way boundedCache does, rather than leaving /metrics blind whenever
+// CACHE_BACKEND=disk.
+func TestDiskStorageStats(t *testing.T) {
+	ds, err := newDiskStorage(t.TempDir(), 2, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("newDiskStorage: %v", err)
+	}
+
+	entry := func(body string) *CacheEntry {
+		return &CacheEntry{
+			Response:   memBlob([]byte(body)),
+			StatusCode: 200,
+			ExpiresAt:  time.Now().Add(time.Hour),
+		}
+	}
+
+	ds.Set(RequestKey{URL: "/a", Method: "GET"}, entry("aaa"))
+	ds.Set(RequestKey{URL: "/b", Method: "GET"}, entry("bb"))
+
+	stats := ds.Stats()
+	if stats.Entries != 2 {
+		t.Errorf("Entries = %d, want 2", stats.Entries)
+	}
+	if stats.Bytes != 5 {
+		t.Errorf("Bytes = %d, want 5", stats.Bytes)
+	}
+	if stats.Evictions != 0 {
+		t.Errorf("Evictions = %d, want 0", stats.Evictions)
+	}
+
+	// Adding a third entry exceeds maxEntries (2), evicting the oldest.
+	ds.Set(RequestKey{URL: "/c", Method: "GET"}, entry("c"))
+
+	stats = ds.Stats()
+	if stats.Entries != 2 {
+		t.Errorf("Entries after eviction = %d, want 2", stats.Entries)
+	}
+	if stats.Evictions != 1 {
+		t.Errorf("Evictions after eviction = %d, want 1", stats.Evictions)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces this proxy's entries within a shared Redis
+// instance that may also be used for other things.
+const redisKeyPrefix = "http-cache-middleware:"
+
+// redisStaleGrace is added on top of an entry's own remaining freshness
+// window when setting its Redis TTL, so a stale-but-revalidatable entry
+// (or one kept around for stale-if-error) isn't evicted by Redis before
+// the application layer is done with it.
+const redisStaleGrace = 24 * time.Hour
+
+// redisStorage is a Storage backend that keeps cached entries in Redis,
+// so multiple proxy replicas behind a load balancer can share one cache.
+type redisStorage struct {
+	client *redis.Client
+}
+
+func newRedisStorage(redisURL string) (*redisStorage, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisStorage{client: redis.NewClient(opts)}, nil
+}
+
+func redisKey(key RequestKey) string {
+	return redisKeyPrefix + key.Method + "\x00" + key.URL + "\x00" + key.VaryHash
+}
+
+// redisRecord bundles the RequestKey in with the entry so Iterate can
+// report it back without Redis needing a secondary index. Response is
+// stored as a plain []byte rather than a Blob, since gob can't encode an
+// interface field and Redis needs the whole body in hand to SET it anyway.
+type redisRecord struct {
+	Key        RequestKey
+	Response   []byte
+	Headers    http.Header
+	StatusCode int
+	ExpiresAt  time.Time
+}
+
+func encodeRecord(key RequestKey, entry *CacheEntry) ([]byte, error) {
+	body, err := readAllBlob(entry.Response)
+	if err != nil {
+		return nil, err
+	}
+	rec := redisRecord{
+		Key:        key,
+		Response:   body,
+		Headers:    entry.Headers,
+		StatusCode: entry.StatusCode,
+		ExpiresAt:  entry.ExpiresAt,
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(data []byte) (*redisRecord, error) {
+	var rec redisRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func recordEntry(rec *redisRecord) *CacheEntry {
+	return &CacheEntry{
+		Response:   memBlob(rec.Response),
+		Headers:    rec.Headers,
+		StatusCode: rec.StatusCode,
+		ExpiresAt:  rec.ExpiresAt,
+	}
+}
+
+func (rs *redisStorage) Get(key RequestKey) (*CacheEntry, bool) {
+	data, err := rs.client.Get(context.Background(), redisKey(key)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	rec, err := decodeRecord(data)
+	if err != nil {
+		return nil, false
+	}
+	return recordEntry(rec), true
+}
+
+// Set is always the last thing done with entry.Response (callers write the
+// response to the client, if any, before persisting), so it owns closing
+// it once the body has been read out for encoding.
+func (rs *redisStorage) Set(key RequestKey, entry *CacheEntry) {
+	defer entry.Response.Close()
+
+	data, err := encodeRecord(key, entry)
+	if err != nil {
+		log.Printf("redis storage: failed to encode entry: %v", err)
+		return
+	}
+
+	ttl := time.Until(entry.ExpiresAt) + redisStaleGrace
+	if err := rs.client.Set(context.Background(), redisKey(key), data, ttl).Err(); err != nil {
+		log.Printf("redis storage: failed to set entry: %v", err)
+	}
+}
+
+func (rs *redisStorage) Delete(key RequestKey) {
+	rs.client.Del(context.Background(), redisKey(key))
+}
+
+// Iterate visits every entry in the proxy's Redis keyspace via SCAN, so
+// it doesn't block the server the way KEYS would on a large instance.
+func (rs *redisStorage) Iterate(fn func(key RequestKey, entry *CacheEntry) bool) {
+	ctx := context.Background()
+	iter := rs.client.Scan(ctx, 0, redisKeyPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		data, err := rs.client.Get(ctx, iter.Val()).Bytes()
+		if err != nil {
+			continue
+		}
+		rec, err := decodeRecord(data)
+		if err != nil {
+			continue
+		}
+		if !fn(rec.Key, recordEntry(rec)) {
+			return
+		}
+	}
+}
+
+// Stats reports the proxy's current footprint within the shared Redis
+// instance, walking the same namespaced keyspace Iterate does. Evictions is
+// always 0: unlike the memory and disk backends, this one never decides to
+// drop an entry itself — that's left entirely to each key's own TTL and
+// Redis's own maxmemory policy.
+func (rs *redisStorage) Stats() cacheStats {
+	ctx := context.Background()
+	var stats cacheStats
+	iter := rs.client.Scan(ctx, 0, redisKeyPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		stats.Entries++
+		if n, err := rs.client.StrLen(ctx, iter.Val()).Result(); err == nil {
+			stats.Bytes += n
+		}
+	}
+	return stats
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// spillThreshold is the response size above which a fetched body is
+// spilled to a temp file instead of being held as a single []byte.
+const spillThreshold = 1 << 20 // 1 MiB
+
+// flushWriter wraps an http.ResponseWriter so that every Write is pushed
+// to the client immediately, rather than sitting in a buffer until the
+// handler returns — required for the client to see bytes as they're
+// teed from the upstream response instead of only once it fully arrives.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) io.Writer {
+	f, _ := w.(http.Flusher)
+	return &flushWriter{w: w, f: f}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+// spillFile is a reference-counted handle around a temp file shared by a
+// growingFile (written by the in-progress fetch, read concurrently by
+// coalesced followers) and, once the fetch completes, the fileBlob that
+// succeeds it for as long as the response stays in the cache. The fetch
+// itself holds the initial reference; every reader that might still be
+// mid-stream — a follower's growingFileReader, or a cache-HIT's pinned
+// fileBlob — holds one of its own for as long as it's reading. The
+// underlying file is only closed and removed once every reference has
+// been released, so whichever of those finishes last is the one that
+// actually tears it down, rather than the fetch/eviction path doing so
+// unconditionally the instant it's "done".
+type spillFile struct {
+	file *os.File
+
+	mu   sync.Mutex
+	refs int
+}
+
+// newSpillFile wraps f with a single reference, owned by the caller (the
+// in-progress fetch).
+func newSpillFile(f *os.File) *spillFile {
+	return &spillFile{file: f, refs: 1}
+}
+
+// acquire adds a reference, to be matched by a later release once the
+// holder is done reading.
+func (sf *spillFile) acquire() {
+	sf.mu.Lock()
+	sf.refs++
+	sf.mu.Unlock()
+}
+
+// release drops a reference, closing and removing the underlying file
+// once nothing holds one any longer.
+func (sf *spillFile) release() {
+	sf.mu.Lock()
+	sf.refs--
+	done := sf.refs == 0
+	sf.mu.Unlock()
+	if done {
+		sf.file.Close()
+		os.Remove(sf.file.Name())
+	}
+}
+
+// growingFile is an append-only file that multiple readers can stream
+// from concurrently while it's still being written to — used so
+// coalesced followers can start reading a response body as soon as
+// bytes are available instead of waiting for the fetch to finish.
+type growingFile struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	sf   *spillFile
+	size int64
+	done bool
+	err  error
+}
+
+func newGrowingFile(f *os.File) *growingFile {
+	gf := &growingFile{sf: newSpillFile(f)}
+	gf.cond = sync.NewCond(&gf.mu)
+	return gf
+}
+
+// Write appends p to the file and wakes any readers blocked waiting for
+// more data. It satisfies io.Writer so a growingFile can be used as one
+// leg of an io.MultiWriter tee.
+func (gf *growingFile) Write(p []byte) (int, error) {
+	n, err := gf.sf.file.Write(p)
+	gf.mu.Lock()
+	gf.size += int64(n)
+	gf.cond.Broadcast()
+	gf.mu.Unlock()
+	return n, err
+}
+
+// Finish marks the file as complete, with fetchErr set if the upstream
+// read failed partway through. Readers blocked at the current end of the
+// file are released.
+func (gf *growingFile) Finish(fetchErr error) {
+	gf.mu.Lock()
+	gf.done = true
+	gf.err = fetchErr
+	gf.cond.Broadcast()
+	gf.mu.Unlock()
+}
+
+// NewReader returns an io.ReadCloser over the file from the start,
+// blocking for more data until Finish is called rather than returning a
+// short read or premature EOF. It holds a reference on the underlying
+// spill file for as long as it's open, so the file can't be torn out from
+// under a follower that's paused between reads (e.g. waiting on a slow
+// client) even if the fetch finishes and decides to release its own
+// reference in the meantime. Callers should Close it once done; Read
+// also closes it automatically on EOF or error.
+func (gf *growingFile) NewReader() io.ReadCloser {
+	gf.sf.acquire()
+	return &growingFileReader{gf: gf}
+}
+
+type growingFileReader struct {
+	gf     *growingFile
+	pos    int64
+	mu     sync.Mutex
+	closed bool
+}
+
+func (r *growingFileReader) Read(p []byte) (int, error) {
+	gf := r.gf
+
+	gf.mu.Lock()
+	for r.pos >= gf.size && !gf.done {
+		gf.cond.Wait()
+	}
+	size, done, err := gf.size, gf.done, gf.err
+	gf.mu.Unlock()
+
+	if r.pos >= size {
+		if err == nil {
+			err = io.EOF
+		}
+		r.Close()
+		return 0, err
+	}
+
+	if want := size - r.pos; int64(len(p)) > want {
+		p = p[:want]
+	}
+	n, readErr := gf.sf.file.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if readErr == io.EOF && r.pos < size {
+		// ReadAt can report EOF at the file's current length even though
+		// more bytes are still being appended; that's not a real EOF here.
+		readErr = nil
+	}
+	_ = done
+	if readErr != nil {
+		r.Close()
+	}
+	return n, readErr
+}
+
+// Close releases this reader's reference on the spill file. It's safe to
+// call more than once — only the first call releases — since Read also
+// calls it on terminal EOF/error.
+func (r *growingFileReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.gf.sf.release()
+	return nil
+}
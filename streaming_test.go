@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// TestSpillFileRefcounting ensures the backing file is only closed and
+// removed once every acquired reference has been released, whichever order
+// they're released in — the invariant growingFile followers and pinned
+// fileBlob reads both depend on to avoid reading (or double-closing) a
+// torn-down file.
+func TestSpillFileRefcounting(t *testing.T) {
+	f, err := os.CreateTemp("", "httpcache-spill-test-*")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	path := f.Name()
+
+	sf := newSpillFile(f) // refs = 1, owned by the caller below
+	sf.acquire()          // refs = 2
+	sf.acquire()          // refs = 3
+
+	sf.release() // refs = 2
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file removed early with refs still held: %v", err)
+	}
+
+	sf.release() // refs = 1
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file removed early with a ref still held: %v", err)
+	}
+
+	sf.release() // refs = 0
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file still present after last release: err = %v", err)
+	}
+}
+
+// TestGrowingFileReaderStreamsWhileWriting ensures a growingFileReader can
+// read bytes as they're written rather than waiting for Finish, and
+// correctly reports EOF only once Finish is called.
+func TestGrowingFileReaderStreamsWhileWriting(t *testing.T) {
+	f, err := os.CreateTemp("", "httpcache-spill-test-*")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	gf := newGrowingFile(f)
+
+	reader := gf.NewReader()
+	defer reader.Close()
+
+	if _, err := gf.Write([]byte("hello ")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(buf) != "hello " {
+		t.Fatalf("got %q, want %q", buf, "hello ")
+	}
+
+	gf.Write([]byte("world"))
+	gf.Finish(nil)
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(rest) != "world" {
+		t.Fatalf("got %q, want %q", rest, "world")
+	}
+}
+
+// TestFileBlobPinBlocksReclaim ensures pinning a fileBlob keeps its spill
+// file alive across a concurrent Close, so a paused cache-HIT read can't
+// have its backing file removed out from under it by an eviction.
+func TestFileBlobPinBlocksReclaim(t *testing.T) {
+	f, err := os.CreateTemp("", "httpcache-spill-test-*")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	if _, err := f.WriteString("cached body"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	path := f.Name()
+
+	blob := newFileBlob(newSpillFile(f), 11)
+
+	unpin := pinBlob(blob)
+	blob.Close() // simulates the cache evicting this entry mid-read
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file removed while pinned: %v", err)
+	}
+
+	buf := make([]byte, 11)
+	if _, err := blob.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt after concurrent Close: %v", err)
+	}
+	if string(buf) != "cached body" {
+		t.Fatalf("got %q, want %q", buf, "cached body")
+	}
+
+	unpin()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file still present after unpin: err = %v", err)
+	}
+}